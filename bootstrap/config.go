@@ -0,0 +1,35 @@
+package bootstrap
+
+import (
+	"time"
+
+	"github.com/buildkite/agent/bootstrap/hook"
+)
+
+// Config holds the subset of agent configuration the hook subsystem reads.
+// It doesn't attempt to model the rest of the real bootstrap config (job
+// environment, working directory, etc.) - just the knobs hookRunnerType and
+// hookScriptWrapper.Run need, so that hook runner selection and execution
+// limits are no longer read straight from the environment.
+type Config struct {
+	// HookRunner selects which hook.Runner implementation hooks execute
+	// under. Empty falls back to BUILDKITE_HOOK_RUNNER, and then to
+	// hook.RunnerTypeDiff.
+	HookRunner hook.RunnerType
+
+	// HookTimeout and HookKillGracePeriod bound a single hook's execution;
+	// see hook.RunOptions.
+	HookTimeout         time.Duration
+	HookKillGracePeriod time.Duration
+
+	// HookEnvAllow, HookEnvDeny, and HookEnvRedact are the patterns
+	// hook.NewPolicy filters a hook's exported environment through before
+	// it's merged into the bootstrap env; see hook_env_allow/deny/redact.
+	HookEnvAllow  []string
+	HookEnvDeny   []string
+	HookEnvRedact []string
+
+	// HookEnvRequireSigned is hook_env_require_signed: reject every
+	// exported variable unless it carries a valid signature.
+	HookEnvRequireSigned bool
+}
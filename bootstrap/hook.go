@@ -1,134 +1,101 @@
 package bootstrap
 
 import (
-	"fmt"
-	"io/ioutil"
+	"context"
 	"os"
-	"path/filepath"
-	"runtime"
+	"strings"
 
-	"github.com/buildkite/agent/bootstrap/shell"
+	"github.com/buildkite/agent/bootstrap/hook"
 	"github.com/buildkite/agent/env"
 )
 
 // Hooks get "sourced" into the bootstrap in the sense that they get the
 // environment set for them and then we capture any extra environment variables
 // that are exported in the script.
+//
+// How that capture actually happens is delegated to a hook.Runner (see
+// bootstrap/hook), which lets us swap strategies (a portable diff-file based
+// one, and a pipe-based one that avoids touching disk) without touching call
+// sites.
 
-// The tricky thing is that it's impossible to grab the ENV of a child process
-// before it finishes, so we've got an awesome (ugly) hack to get around this.
-// We write the ENV to file, run the hook and then write the ENV back to another file.
-// Then we can use the diff of the two to figure out what changes to make to the
-// bootstrap. Horrible, but effective.
-
-// hookScriptWrapper wraps a hook script with env collection and then provides
-// a way to get the difference between the environment before the hook is run and
-// after it
 type hookScriptWrapper struct {
-	hookPath      string
-	scriptFile    *os.File
-	beforeEnvFile *os.File
-	afterEnvFile  *os.File
+	hook.Runner
+	cfg *Config
 }
 
-func newHookScriptWrapper(hookPath string) (*hookScriptWrapper, error) {
-	var h = &hookScriptWrapper{
-		hookPath: hookPath,
+// hookRunnerType decides which hook.Runner implementation new hooks are
+// executed with: cfg.HookRunner if set, otherwise BUILDKITE_HOOK_RUNNER,
+// otherwise hook.RunnerTypeDiff.
+func hookRunnerType(cfg *Config) hook.RunnerType {
+	if cfg != nil && cfg.HookRunner != "" {
+		return cfg.HookRunner
 	}
-
-	var err error
-
-	// Create a temporary file that we'll put the hook runner code in
-	h.scriptFile, err = shell.TempFileWithExtension(normalizeScriptFileName(
-		`buildkite-agent-bootstrap-hook-runner`,
-	))
-	if err != nil {
-		return nil, err
+	switch r := hook.RunnerType(os.Getenv(hook.EnvBuildkiteHookRunner)); r {
+	case hook.RunnerTypeChannel, hook.RunnerTypePowerShell:
+		return r
 	}
+	return hook.RunnerTypeDiff
+}
 
-	// We'll pump the ENV before the hook into this temp file
-	h.beforeEnvFile, err = shell.TempFileWithExtension(
-		`buildkite-agent-bootstrap-hook-env-before`,
-	)
-	if err != nil {
-		return nil, err
+// hookEnvPolicy builds the quarantine policy a hook's exports are filtered
+// through before being merged into the bootstrap env, from cfg's
+// HookEnvAllow/Deny/Redact/RequireSigned fields, falling back to the
+// BUILDKITE_HOOK_ENV_ALLOW/DENY/REDACT/REQUIRE_SIGNED environment
+// variables when cfg is nil (same pattern as hookRunnerType).
+func hookEnvPolicy(cfg *Config) *hook.Policy {
+	if cfg != nil {
+		return hook.NewPolicy(cfg.HookEnvAllow, cfg.HookEnvDeny, cfg.HookEnvRedact, cfg.HookEnvRequireSigned)
 	}
-	h.beforeEnvFile.Close()
-
-	// We'll then pump the ENV _after_ the hook into this temp file
-	h.afterEnvFile, err = shell.TempFileWithExtension(
-		`buildkite-agent-bootstrap-hook-env-after`,
+	return hook.NewPolicy(
+		splitHookEnvList(os.Getenv("BUILDKITE_HOOK_ENV_ALLOW")),
+		splitHookEnvList(os.Getenv("BUILDKITE_HOOK_ENV_DENY")),
+		splitHookEnvList(os.Getenv("BUILDKITE_HOOK_ENV_REDACT")),
+		os.Getenv("BUILDKITE_HOOK_ENV_REQUIRE_SIGNED") == "true",
 	)
-	if err != nil {
-		return nil, err
-	}
-	h.afterEnvFile.Close()
-
-	absolutePathToHook, err := filepath.Abs(h.hookPath)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to find absolute path to \"%s\" (%s)", h.hookPath, err)
-	}
+}
 
-	// Create the hook runner code
-	var script string
-	if runtime.GOOS == "windows" {
-		script = "@echo off\n" +
-			"SETLOCAL ENABLEDELAYEDEXPANSION\n" +
-			"SET > \"" + h.beforeEnvFile.Name() + "\"\n" +
-			"CALL \"" + absolutePathToHook + "\"\n" +
-			"SET BUILDKITE_LAST_HOOK_EXIT_STATUS=!ERRORLEVEL!\n" +
-			"SET > \"" + h.afterEnvFile.Name() + "\"\n" +
-			"EXIT %BUILDKITE_LAST_HOOK_EXIT_STATUS%"
-	} else {
-		script = "#!/bin/bash\n" +
-			"export -p > \"" + h.beforeEnvFile.Name() + "\"\n" +
-			". \"" + absolutePathToHook + "\"\n" +
-			"BUILDKITE_LAST_HOOK_EXIT_STATUS=$?\n" +
-			"export -p > \"" + h.afterEnvFile.Name() + "\"\n" +
-			"exit $BUILDKITE_LAST_HOOK_EXIT_STATUS"
+// splitHookEnvList parses a comma-separated BUILDKITE_HOOK_ENV_* value into
+// a pattern list, treating an empty string as "unset" rather than a single
+// empty pattern.
+func splitHookEnvList(s string) []string {
+	if s == "" {
+		return nil
 	}
+	return strings.Split(s, ",")
+}
 
-	// Write the hook script to the runner then close the file so we can run it
-	h.scriptFile.WriteString(script)
-	h.scriptFile.Close()
+// newHookRegistry builds a HookRegistry over dirs wired up to the policy
+// hookEnvPolicy(cfg) builds, so that RunEvent filters every hook's exports
+// through it before merging them into the bootstrap env.
+func newHookRegistry(cfg *Config, dirs map[hook.Scope]string) *hook.HookRegistry {
+	registry := hook.NewRegistry(dirs)
+	registry.Policy = hookEnvPolicy(cfg)
+	return registry
+}
 
-	// Make script executable
-	if err = addExecutePermissiontoFile(h.scriptFile.Name()); err != nil {
-		return h, err
+func newHookScriptWrapper(cfg *Config, hookPath string) (*hookScriptWrapper, error) {
+	runner, err := hook.NewRunner(hookRunnerType(cfg), hookPath)
+	if err != nil {
+		return nil, err
 	}
 
-	return h, nil
-}
-
-// Path returns the path to the wrapper script, this is the one that should be executed
-func (h *hookScriptWrapper) Path() string {
-	return h.scriptFile.Name()
+	return &hookScriptWrapper{Runner: runner, cfg: cfg}, nil
 }
 
-// Close cleans up the wrapper script and the environment files
-func (h *hookScriptWrapper) Close() {
-	os.Remove(h.scriptFile.Name())
-	os.Remove(h.beforeEnvFile.Name())
-	os.Remove(h.afterEnvFile.Name())
+// Run executes the hook under ctx, bounding it with cfg's HookTimeout and
+// HookKillGracePeriod and streaming its output through logger, instead of a
+// caller having to exec runner.Path() itself with no cancellation, timeout,
+// or incremental output.
+func (h *hookScriptWrapper) Run(ctx context.Context, logger hook.Logger) (*hook.Result, error) {
+	opts := hook.RunOptions{Logger: logger}
+	if h.cfg != nil {
+		opts.Timeout = h.cfg.HookTimeout
+		opts.KillGracePeriod = h.cfg.HookKillGracePeriod
+	}
+	return hook.Run(ctx, h.Runner, opts)
 }
 
 // ChangedEnvironment returns and environment variables exported during the hook run
 func (h *hookScriptWrapper) ChangedEnvironment() (*env.Environment, error) {
-	beforeEnvContents, err := ioutil.ReadFile(h.beforeEnvFile.Name())
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read \"%s\" (%s)", h.beforeEnvFile.Name(), err)
-	}
-
-	afterEnvContents, err := ioutil.ReadFile(h.afterEnvFile.Name())
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read \"%s\" (%s)", h.afterEnvFile.Name(), err)
-	}
-
-	beforeEnv := env.FromExport(string(beforeEnvContents))
-	afterEnv := env.FromExport(string(afterEnvContents))
-
-	// This status isn't needed outside this hook environment and it leaks on windows
-	_ = afterEnv.Remove(`BUILDKITE_LAST_HOOK_EXIT_STATUS`)
-
-	return afterEnv.Diff(beforeEnv), nil
+	return h.Runner.ChangedEnvironment()
 }
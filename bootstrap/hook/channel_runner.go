@@ -0,0 +1,224 @@
+package hook
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/agent/env"
+)
+
+// channelEnvFD is the file descriptor the wrapper script writes its framed
+// environment snapshot to. It must be the first (and only) entry in the
+// exec.Cmd's ExtraFiles, which bash sees as fd 3.
+const channelEnvFD = 3
+
+// channelRunner captures the environment a hook exports by having the
+// wrapper script write it straight back to the agent process down a pipe,
+// instead of round-tripping it through disk. Like diffRunner it snapshots
+// the environment both before and after the hook runs and diffs the two -
+// the wrapper just does it once per run instead of twice per disk file -
+// but it also preserves bash arrays and exported functions, handles
+// multi-line values cleanly, and can tell a variable the hook unset apart
+// from one it never touched by reporting the names that existed before the
+// hook and were gone afterwards explicitly, via Removed(), instead of
+// trying to cram that into an *env.Environment.
+//
+// Callers are expected to add ExtraFiles() to the exec.Cmd that runs Path(),
+// and to close it once the command has started so that readFrames() sees
+// EOF when the hook (and every fork it made) has exited.
+type channelRunner struct {
+	hookPath   string
+	scriptFile *os.File
+	pipeWriter *os.File
+
+	done    chan struct{}
+	changed *env.Environment
+	removed []string
+	readErr error
+}
+
+func newChannelRunner(hookPath string) (*channelRunner, error) {
+	h := &channelRunner{hookPath: hookPath}
+
+	var err error
+	h.scriptFile, err = tempFile(normalizeScriptFileName(
+		`buildkite-agent-bootstrap-hook-runner`,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	pipeReader, pipeWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create hook env pipe (%s)", err)
+	}
+	h.pipeWriter = pipeWriter
+
+	h.done = make(chan struct{})
+	go h.readFrames(pipeReader)
+
+	absolutePathToHook, err := filepath.Abs(h.hookPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find absolute path to \"%s\" (%s)", h.hookPath, err)
+	}
+
+	script := "#!/bin/bash\n" +
+		writeFrameFunctionScript(channelEnvFD) +
+		dumpEnvFramesScript() +
+		". \"" + absolutePathToHook + "\"\n" +
+		"BUILDKITE_LAST_HOOK_EXIT_STATUS=$?\n" +
+		"buildkite_write_frame \"$BUILDKITE_LAST_HOOK_EXIT_STATUS\"\n" +
+		dumpEnvFramesScript() +
+		fmt.Sprintf("exec %d>&-\n", channelEnvFD) +
+		"exit $BUILDKITE_LAST_HOOK_EXIT_STATUS"
+
+	h.scriptFile.WriteString(script)
+	h.scriptFile.Close()
+
+	if err = addExecutePermissiontoFile(h.scriptFile.Name()); err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+// writeFrameFunctionScript defines the bash helper every frame is written
+// through: a decimal length, a newline, then exactly that many bytes.
+func writeFrameFunctionScript(fd int) string {
+	return fmt.Sprintf(`buildkite_write_frame() {
+  printf '%%d\n' "${#1}" >&%d
+  printf '%%s' "$1" >&%d
+}
+`, fd, fd)
+}
+
+// dumpEnvFramesScript writes three frames capturing a point-in-time
+// snapshot of exported variables, exported functions, and arrays. It's run
+// once before the hook (the "before" snapshot) and once after (the "after"
+// snapshot), so the Go side can diff the two instead of just reporting
+// everything the hook's shell happened to have exported.
+func dumpEnvFramesScript() string {
+	return `buildkite_write_frame "$(declare -px)"
+buildkite_write_frame "$(declare -fx)"
+buildkite_write_frame "$(declare -p $(compgen -A arrayvar) 2>/dev/null)"
+`
+}
+
+// ExtraFiles returns the files that need to be attached to the hook's
+// exec.Cmd (as ExtraFiles) so that the wrapper script's fd 3 is connected to
+// this runner's pipe.
+func (h *channelRunner) ExtraFiles() []*os.File {
+	return []*os.File{h.pipeWriter}
+}
+
+// readFrames reads the seven length-prefixed frames the wrapper script
+// writes - a before snapshot (vars, funcs, arrays), the hook's exit status,
+// and an after snapshot (vars, funcs, arrays) - diffs the two snapshots,
+// and records both what changed and what disappeared entirely. It runs in
+// its own goroutine for the lifetime of the hook so that the wrapper never
+// blocks writing to a full pipe.
+func (h *channelRunner) readFrames(r *os.File) {
+	defer close(h.done)
+	defer r.Close()
+
+	reader := bufio.NewReader(r)
+
+	frames := make([]string, 7)
+	for i := range frames {
+		frame, err := readFrame(reader)
+		if err != nil {
+			h.readErr = fmt.Errorf("Failed to read hook env frame %d (%s)", i, err)
+			return
+		}
+		frames[i] = frame
+	}
+
+	before := mergeExportFrames(frames[0], frames[1], frames[2])
+	after := mergeExportFrames(frames[4], frames[5], frames[6])
+
+	h.changed = after.Diff(before)
+	h.removed = removedKeys(before, after)
+}
+
+// mergeExportFrames combines a vars/funcs/arrays triple of "declare -px"
+// style output into a single *env.Environment.
+func mergeExportFrames(vars, funcs, arrays string) *env.Environment {
+	merged := env.FromExport(vars)
+	merged = merged.Merge(env.FromExport(funcs))
+	merged = merged.Merge(env.FromExport(arrays))
+	return merged
+}
+
+// removedKeys returns the names present in before but absent from after -
+// the variables (or functions, or arrays) the hook unset. diffRunner can't
+// represent this at all, since it only has two snapshots to look at
+// afterwards with no way to tell "absent" from "irrelevant".
+func removedKeys(before, after *env.Environment) []string {
+	afterMap := after.ToMap()
+
+	var removed []string
+	for name := range before.ToMap() {
+		if _, ok := afterMap[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return removed
+}
+
+// readFrame reads a single length-prefixed frame: an ASCII decimal length,
+// a newline, and then exactly that many bytes of content.
+func readFrame(r *bufio.Reader) (string, error) {
+	lengthLine, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(lengthLine))
+	if err != nil {
+		return "", fmt.Errorf("invalid frame length %q (%s)", lengthLine, err)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+// Path returns the path to the wrapper script, this is the one that should be executed
+func (h *channelRunner) Path() string {
+	return h.scriptFile.Name()
+}
+
+// Close cleans up the wrapper script and this runner's end of the pipe.
+func (h *channelRunner) Close() {
+	os.Remove(h.scriptFile.Name())
+	h.pipeWriter.Close()
+}
+
+// ChangedEnvironment blocks until the hook has finished and its frames have
+// been read, then returns the variables it added or changed relative to
+// before the hook ran. Use Removed() to find out what it unset.
+func (h *channelRunner) ChangedEnvironment() (*env.Environment, error) {
+	<-h.done
+	if h.readErr != nil {
+		return nil, h.readErr
+	}
+	return h.changed, nil
+}
+
+// Removed returns the names of variables, functions, or arrays that were
+// exported before the hook ran but were gone afterwards.
+func (h *channelRunner) Removed() ([]string, error) {
+	<-h.done
+	if h.readErr != nil {
+		return nil, h.readErr
+	}
+	return h.removed, nil
+}
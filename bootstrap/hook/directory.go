@@ -0,0 +1,109 @@
+package hook
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/buildkite/agent/env"
+)
+
+// continueOnErrorMarker, when present in a hooks/<name>.d directory, means
+// a failing hook in the chain doesn't abort the rest of it.
+const continueOnErrorMarker = "continue-on-error"
+
+// Chained is a single hook file discovered inside a "<name>.d" directory,
+// in the lexical order it should run.
+type Chained struct {
+	Path string
+}
+
+// Directory returns the hooks found in path, a "<name>.d" directory,
+// ordered lexically by filename - the same ordering rule used by the many
+// Git server implementations this pattern is borrowed from. Sidecar
+// "*.yaml" metadata files are not treated as hooks in their own right.
+//
+// If path doesn't exist, Directory returns (nil, nil): a single-file hook
+// is still a perfectly valid way to bind an event, and callers should fall
+// back to that.
+func Directory(path string) ([]Chained, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) == ".yaml" {
+			continue
+		}
+		if !isExecutable(entry) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	hooks := make([]Chained, 0, len(names))
+	for _, name := range names {
+		hooks = append(hooks, Chained{Path: filepath.Join(path, name)})
+	}
+
+	return hooks, nil
+}
+
+// ContinueOnError reports whether a failing hook in dir's chain should be
+// logged and skipped rather than aborting the rest of the chain, as
+// indicated by the presence of a "continue-on-error" marker file.
+func ContinueOnError(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, continueOnErrorMarker))
+	return err == nil
+}
+
+// RunChain runs every hook in a "<name>.d" directory in order with
+// runnerType, composing their environment diffs left-to-right (each hook
+// sees the previous hooks' exports merged into the env run passes it), and
+// stops at the first failure unless ContinueOnError(path) is true.
+//
+// run is called once per hook with the runner to execute and the merged
+// environment accumulated so far; it's expected to apply merged to the
+// child's environment before executing runner.Path().
+func RunChain(path string, runnerType RunnerType, merged *env.Environment, run func(h Chained, runner Runner, merged *env.Environment) error) (*env.Environment, error) {
+	hooks, err := Directory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	continueOnError := ContinueOnError(path)
+
+	for _, h := range hooks {
+		runner, err := NewRunner(runnerType, h.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		runErr := run(h, runner, merged)
+
+		changed, envErr := runner.ChangedEnvironment()
+		runner.Close()
+
+		if runErr != nil {
+			if !continueOnError {
+				return merged, runErr
+			}
+			continue
+		}
+
+		if envErr != nil {
+			return nil, envErr
+		}
+
+		merged = merged.Merge(changed)
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,118 @@
+package hook
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/env"
+)
+
+func TestDirectory_LexicalOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"20-metrics", "10-secrets", "05-setup"} {
+		writeHook(t, dir, name, "#!/bin/bash\n")
+	}
+	// Sidecar metadata files shouldn't be treated as hooks in their own right.
+	writeMetadata(t, dir, "10-secrets", "events: [pre-command]\n")
+
+	hooks, err := Directory(dir)
+	if err != nil {
+		t.Fatalf("Directory() error = %v", err)
+	}
+
+	var got []string
+	for _, h := range hooks {
+		got = append(got, filepath.Base(h.Path))
+	}
+
+	want := []string{"05-setup", "10-secrets", "20-metrics"}
+	if len(got) != len(want) {
+		t.Fatalf("Directory() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Directory()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDirectory_SkipsNonExecutableFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	writeHook(t, dir, "10-secrets", "#!/bin/bash\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a hook\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	hooks, err := Directory(dir)
+	if err != nil {
+		t.Fatalf("Directory() error = %v", err)
+	}
+
+	if len(hooks) != 1 || filepath.Base(hooks[0].Path) != "10-secrets" {
+		t.Errorf("Directory() = %v, want only the executable 10-secrets hook", hooks)
+	}
+}
+
+func TestDirectory_NotExist(t *testing.T) {
+	hooks, err := Directory(filepath.Join(t.TempDir(), "nope.d"))
+	if err != nil {
+		t.Fatalf("Directory() error = %v", err)
+	}
+	if hooks != nil {
+		t.Errorf("Directory() = %v, want nil for a missing directory", hooks)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+
+	if ContinueOnError(dir) {
+		t.Errorf("ContinueOnError() = true without a marker file, want false")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, continueOnErrorMarker), nil, 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if !ContinueOnError(dir) {
+		t.Errorf("ContinueOnError() = false with the marker file present, want true")
+	}
+}
+
+func TestRunChain_StopsOnFailureByDefault(t *testing.T) {
+	dir := t.TempDir()
+
+	writeHook(t, dir, "10-ok", "#!/bin/bash\nexport FIRST=ran\n")
+	writeHook(t, dir, "20-fails", "#!/bin/bash\nexit 1\n")
+	writeHook(t, dir, "30-never-runs", "#!/bin/bash\nexport THIRD=ran\n")
+
+	ran := map[string]bool{}
+	merged, err := RunChain(dir, RunnerTypeDiff, envFromSliceNil(), func(h Chained, runner Runner, merged *env.Environment) error {
+		ran[filepath.Base(h.Path)] = true
+		return runCmd(runner.Path())
+	})
+
+	if err == nil {
+		t.Fatalf("RunChain() error = nil, want the 20-fails error")
+	}
+	if ran["30-never-runs"] {
+		t.Errorf("RunChain() ran 30-never-runs after a failure without continue-on-error")
+	}
+	if merged.ToMap()["FIRST"] != "" && merged.ToMap()["FIRST"] != "ran" {
+		t.Errorf("merged env = %+v", merged.ToMap())
+	}
+}
+
+// envFromSliceNil exists purely so the test above reads naturally; it's
+// just the empty starting environment every chain run begins with.
+func envFromSliceNil() *env.Environment {
+	return env.FromSlice(nil)
+}
+
+func runCmd(path string) error {
+	return exec.Command(path).Run()
+}
@@ -0,0 +1,71 @@
+// Package hook provides strategies for executing Buildkite hooks and
+// capturing any environment changes they make while they run.
+//
+// A hook is "sourced" into its own wrapper script in the sense that it gets
+// the bootstrap's environment set for it, and the wrapper then reports back
+// whatever extra environment variables the hook exported. How that report
+// gets back to the agent process is a Runner's job.
+package hook
+
+import (
+	"github.com/buildkite/agent/env"
+)
+
+// RunnerType identifies which Runner implementation should be used to
+// execute a hook.
+type RunnerType string
+
+const (
+	// RunnerTypeDiff is the original strategy: it writes the environment to
+	// a file before and after the hook runs and diffs the two. Retained for
+	// backwards compatibility and for Windows cmd.exe hooks.
+	RunnerTypeDiff RunnerType = "diff"
+
+	// RunnerTypeChannel streams a framed environment snapshot back from the
+	// hook over a pipe instead of round-tripping it through disk.
+	RunnerTypeChannel RunnerType = "channel"
+
+	// RunnerTypePowerShell runs the hook under PowerShell and captures its
+	// environment as JSON. It's selected automatically for ".ps1" hooks
+	// regardless of what RunnerType was asked for; see NewRunner.
+	RunnerTypePowerShell RunnerType = "powershell"
+)
+
+// EnvBuildkiteHookRunner is the agent config / environment variable that
+// selects which RunnerType is used to run hooks.
+const EnvBuildkiteHookRunner = "BUILDKITE_HOOK_RUNNER"
+
+// Runner wraps a hook script so that it can be executed and have its
+// effects on the environment observed afterwards.
+type Runner interface {
+	// Path returns the path to the script that should actually be executed
+	// in place of the hook.
+	Path() string
+
+	// ChangedEnvironment returns the environment variables that were
+	// exported or changed while the hook was running. Variables the hook
+	// unset are not included.
+	ChangedEnvironment() (*env.Environment, error)
+
+	// Close cleans up any temporary resources the runner created.
+	Close()
+}
+
+// NewRunner creates a Runner for hookPath using the strategy named by
+// runnerType. An empty or unrecognised runnerType falls back to
+// RunnerTypeDiff. Regardless of runnerType, a ".ps1" hookPath always gets
+// the PowerShell runner, since neither of the other two can execute it.
+func NewRunner(runnerType RunnerType, hookPath string) (Runner, error) {
+	if IsPowerShellHook(hookPath, "") {
+		return newPowershellRunner(hookPath)
+	}
+
+	switch runnerType {
+	case RunnerTypeChannel:
+		return newChannelRunner(hookPath)
+	case RunnerTypePowerShell:
+		return newPowershellRunner(hookPath)
+	default:
+		return newDiffRunner(hookPath)
+	}
+}
@@ -0,0 +1,212 @@
+package hook
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// runHookEnv writes hookBody to a temp file, runs it through the named
+// runner type with extraEnv added on top of the agent's own environment,
+// and returns the resulting environment diff.
+func runHookEnv(t *testing.T, runnerType RunnerType, hookBody string, extraEnv []string) (map[string]string, Runner, error) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" && runnerType == RunnerTypeChannel {
+		t.Skip("channel runner is unix-only")
+	}
+
+	dir, err := ioutil.TempDir("", "hook-test")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hookPath := filepath.Join(dir, "hook")
+	if err := ioutil.WriteFile(hookPath, []byte(hookBody), 0700); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	runner, err := NewRunner(runnerType, hookPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := exec.Command(runner.Path())
+	cmd.Env = append(os.Environ(), extraEnv...)
+	if extra, ok := runner.(interface{ ExtraFiles() []*os.File }); ok {
+		cmd.ExtraFiles = extra.ExtraFiles()
+	}
+	if err := cmd.Run(); err != nil {
+		if cr, ok := runner.(*channelRunner); ok {
+			cr.pipeWriter.Close()
+		}
+		return nil, runner, err
+	}
+	if cr, ok := runner.(*channelRunner); ok {
+		cr.pipeWriter.Close()
+	}
+
+	diff, err := runner.ChangedEnvironment()
+	if err != nil {
+		return nil, runner, err
+	}
+
+	return diff.ToMap(), runner, nil
+}
+
+// runHook is runHookEnv without any extra environment on top of the
+// agent's own.
+func runHook(t *testing.T, runnerType RunnerType, hookBody string) (map[string]string, error) {
+	t.Helper()
+
+	got, runner, err := runHookEnv(t, runnerType, hookBody, nil)
+	if runner != nil {
+		defer runner.Close()
+	}
+	return got, err
+}
+
+func TestRunners(t *testing.T) {
+	tests := []struct {
+		name string
+		hook string
+		want map[string]string
+		// runnerTypes restricts which runners the case is exercised
+		// against; nil means both. Arrays and exported functions are a
+		// channel-runner-only capability: export -p (what diffRunner
+		// uses) never prints either, by design of the bash builtin
+		// itself, not a bug in diffRunner.
+		runnerTypes []RunnerType
+	}{
+		{
+			name: "simple variable",
+			hook: "export LLAMA=rock",
+			want: map[string]string{"LLAMA": "rock"},
+		},
+		{
+			name: "multi-line value",
+			hook: "export LLAMA=$'line one\\nline two'",
+			want: map[string]string{"LLAMA": "line one\nline two"},
+		},
+		{
+			name:        "array",
+			hook:        "export LLAMA=(alpaca guanaco vicuna)",
+			want:        map[string]string{"LLAMA": "([0]=\"alpaca\" [1]=\"guanaco\" [2]=\"vicuna\")"},
+			runnerTypes: []RunnerType{RunnerTypeChannel},
+		},
+		{
+			name:        "exported function",
+			hook:        "llama() { echo rock; }\nexport -f llama",
+			want:        map[string]string{"llama": "llama ()\n{\n    echo rock\n}"},
+			runnerTypes: []RunnerType{RunnerTypeChannel},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runnerTypes := tt.runnerTypes
+			if runnerTypes == nil {
+				runnerTypes = []RunnerType{RunnerTypeDiff, RunnerTypeChannel}
+			}
+			for _, runnerType := range runnerTypes {
+				t.Run(string(runnerType), func(t *testing.T) {
+					got, err := runHook(t, runnerType, tt.hook)
+					if err != nil {
+						t.Fatalf("runHook() error = %v", err)
+					}
+					for k, want := range tt.want {
+						if got[k] != want {
+							t.Errorf("env[%q] = %q, want %q", k, got[k], want)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestRunners_UnsetAfterSet checks that neither runner reports a variable
+// the hook exported and then unset again: both only compare a snapshot
+// taken before the hook with one taken after it, and the variable is
+// absent from both.
+func TestRunners_UnsetAfterSet(t *testing.T) {
+	const script = "export LLAMA=rock\nunset LLAMA"
+
+	diffGot, err := runHook(t, RunnerTypeDiff, script)
+	if err != nil {
+		t.Fatalf("runHook(diff) error = %v", err)
+	}
+	if _, ok := diffGot["LLAMA"]; ok {
+		t.Errorf("diff runner unexpectedly reported LLAMA, want it absent from the diff entirely")
+	}
+
+	channelGot, err := runHook(t, RunnerTypeChannel, script)
+	if err != nil {
+		t.Fatalf("runHook(channel) error = %v", err)
+	}
+	if _, ok := channelGot["LLAMA"]; ok {
+		t.Errorf("channel runner reported LLAMA, want it absent since it was unset again after being exported")
+	}
+}
+
+// TestChannelRunner_DoesNotLeakPreExistingVars pins down the bug where the
+// channel runner skipped capturing a before-snapshot: without one, every
+// variable the hook's shell inherited (not just what the hook itself
+// exported) showed up in ChangedEnvironment().
+func TestChannelRunner_DoesNotLeakPreExistingVars(t *testing.T) {
+	got, runner, err := runHookEnv(t, RunnerTypeChannel, "true\n", []string{"PRE_EXISTING_SECRET=shh"})
+	if runner != nil {
+		defer runner.Close()
+	}
+	if err != nil {
+		t.Fatalf("runHookEnv() error = %v", err)
+	}
+
+	if _, ok := got["PRE_EXISTING_SECRET"]; ok {
+		t.Errorf("ChangedEnvironment() leaked PRE_EXISTING_SECRET, want only variables the hook itself changed")
+	}
+	if _, ok := got["PATH"]; ok {
+		t.Errorf("ChangedEnvironment() leaked PATH, want only variables the hook itself changed")
+	}
+}
+
+// TestChannelRunner_Removed exercises unsetting a variable that existed
+// before the hook ran (rather than one it only just exported itself),
+// which is the case TestRunners_UnsetAfterSet didn't cover.
+func TestChannelRunner_Removed(t *testing.T) {
+	got, runner, err := runHookEnv(t, RunnerTypeChannel, "unset PRE_EXISTING\n", []string{"PRE_EXISTING=original"})
+	if runner != nil {
+		defer runner.Close()
+	}
+	if err != nil {
+		t.Fatalf("runHookEnv() error = %v", err)
+	}
+
+	if _, ok := got["PRE_EXISTING"]; ok {
+		t.Errorf("ChangedEnvironment() reported PRE_EXISTING, want it absent since it was only removed")
+	}
+
+	cr, ok := runner.(interface{ Removed() ([]string, error) })
+	if !ok {
+		t.Fatalf("runner does not implement Removed()")
+	}
+
+	removed, err := cr.Removed()
+	if err != nil {
+		t.Fatalf("Removed() error = %v", err)
+	}
+
+	found := false
+	for _, name := range removed {
+		if name == "PRE_EXISTING" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Removed() = %v, want it to include PRE_EXISTING", removed)
+	}
+}
@@ -0,0 +1,82 @@
+// Package hooktest provides helpers for testing what environment variables
+// the bootstrap actually exposes to a hook, without having to write one out
+// by hand in every test.
+package hooktest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// WriteEnvToHook drops an executable hook named event into dir that dumps
+// its entire environment, one NAME=value per line, to a file alongside
+// itself. It returns the path that file will be written to once the hook
+// has run.
+func WriteEnvToHook(t testing.TB, dir, event string) (envFilePath string) {
+	t.Helper()
+
+	envFilePath = filepath.Join(dir, event+".env")
+
+	var body string
+	if runtime.GOOS == "windows" {
+		body = "@echo off\nSET > \"" + envFilePath + "\"\n"
+	} else {
+		body = "#!/bin/bash\nenv > \"" + envFilePath + "\"\n"
+	}
+
+	writeHookFile(t, dir, event, body)
+
+	return envFilePath
+}
+
+// WriteAssertEnvHook drops an executable hook named event into dir that
+// fails (and prints a diagnostic) unless every variable in want is present
+// in its environment with the expected value.
+func WriteAssertEnvHook(t testing.TB, dir, event string, want map[string]string) {
+	t.Helper()
+
+	names := make([]string, 0, len(want))
+	for name := range want {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body string
+	if runtime.GOOS == "windows" {
+		body = "@echo off\n"
+		for _, name := range names {
+			body += fmt.Sprintf(
+				"if not \"%%%s%%\"==\"%s\" (echo expected %s=%s, got \"%%%s%%\" & exit 1)\n",
+				name, want[name], name, want[name], name,
+			)
+		}
+	} else {
+		body = "#!/bin/bash\nset -euo pipefail\n"
+		for _, name := range names {
+			body += fmt.Sprintf(
+				"if [ \"${%s:-}\" != %q ]; then echo \"expected %s=%s, got '${%s:-}'\" >&2; exit 1; fi\n",
+				name, want[name], name, want[name], name,
+			)
+		}
+	}
+
+	writeHookFile(t, dir, event, body)
+}
+
+func writeHookFile(t testing.TB, dir, event, body string) {
+	t.Helper()
+
+	name := event
+	if runtime.GOOS == "windows" {
+		name += ".bat"
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(body), 0700); err != nil {
+		t.Fatalf("hooktest: WriteFile(%q) error = %v", path, err)
+	}
+}
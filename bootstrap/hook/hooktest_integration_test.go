@@ -0,0 +1,60 @@
+package hook
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/bootstrap/hook/hooktest"
+)
+
+// TestRunner_UsesCurrentTempDir checks that runners honour CurrentTempDir,
+// so tests can assert on exactly which temp files a hook run created
+// instead of relying on the process-wide temp directory being cleaned up.
+func TestRunner_UsesCurrentTempDir(t *testing.T) {
+	dir := t.TempDir()
+
+	old := CurrentTempDir
+	CurrentTempDir = func() string { return dir }
+	defer func() { CurrentTempDir = old }()
+
+	hookPath := filepath.Join(dir, "pre-command")
+	if err := os.WriteFile(hookPath, []byte("#!/bin/bash\nexport LLAMA=rock\n"), 0700); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	runner, err := NewRunner(RunnerTypeDiff, hookPath)
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	defer runner.Close()
+
+	if filepath.Dir(runner.Path()) != dir {
+		t.Errorf("runner.Path() = %q, want it under %q", runner.Path(), dir)
+	}
+}
+
+// TestHooktest_WriteAssertEnvHook exercises the hooktest helpers: a hook
+// that asserts on its own environment, run through the diff runner exactly
+// like a pipeline phase would.
+func TestHooktest_WriteAssertEnvHook(t *testing.T) {
+	dir := t.TempDir()
+
+	hooktest.WriteAssertEnvHook(t, dir, "pre-command", map[string]string{
+		"BUILDKITE_PIPELINE": "agent",
+	})
+
+	hookPath := filepath.Join(dir, "pre-command")
+	runner, err := NewRunner(RunnerTypeDiff, hookPath)
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	defer runner.Close()
+
+	cmd := exec.Command(runner.Path())
+	cmd.Env = append(os.Environ(), "BUILDKITE_PIPELINE=agent")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("hook failed: %v\n%s", err, out)
+	}
+}
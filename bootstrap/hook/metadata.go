@@ -0,0 +1,82 @@
+package hook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FailurePolicy controls what a HookRegistry does when a hook exits
+// non-zero.
+type FailurePolicy string
+
+const (
+	// FailurePolicyFail aborts the event's remaining hooks and fails the
+	// step. This is the default.
+	FailurePolicyFail FailurePolicy = "fail"
+
+	// FailurePolicyIgnore logs the failure and continues on to the next
+	// hook bound to the event.
+	FailurePolicyIgnore FailurePolicy = "ignore"
+
+	// FailurePolicyRetry re-runs the hook once more before falling back to
+	// FailurePolicyFail.
+	FailurePolicyRetry FailurePolicy = "retry"
+)
+
+// Metadata is the sidecar `hook.yaml` that lets a hook script declare which
+// events it binds to and how it should be run, instead of being implied
+// purely by its filename. A hook without a sidecar file keeps the legacy
+// behaviour of binding to the single event its filename names.
+type Metadata struct {
+	// Events this hook should be invoked for, e.g. "pre-checkout",
+	// "post-command", "cleanup".
+	Events []string `yaml:"events"`
+
+	// Command overrides the hook's own shebang/interpreter, e.g. to run it
+	// with a specific binary.
+	Command string `yaml:"command,omitempty"`
+
+	// Args are passed to Command as arguments, ahead of the path of the
+	// script being run. They're ignored when Command is empty.
+	Args []string `yaml:"args,omitempty"`
+
+	// Timeout bounds how long the hook is allowed to run for.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// FailurePolicy controls what happens when the hook exits non-zero.
+	FailurePolicy FailurePolicy `yaml:"failure_policy,omitempty"`
+}
+
+// metadataPath returns the sidecar metadata path for a hook script, e.g.
+// "hooks/mythook" -> "hooks/mythook.yaml".
+func metadataPath(hookPath string) string {
+	return hookPath + ".yaml"
+}
+
+// LoadMetadata reads and parses the sidecar hook.yaml for hookPath, if one
+// exists. It returns (nil, nil) when there is no sidecar file, so that
+// plain hook scripts keep working unmodified.
+func LoadMetadata(hookPath string) (*Metadata, error) {
+	contents, err := ioutil.ReadFile(metadataPath(hookPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to read %q (%s)", metadataPath(hookPath), err)
+	}
+
+	var m Metadata
+	if err := yaml.Unmarshal(contents, &m); err != nil {
+		return nil, fmt.Errorf("Failed to parse %q (%s)", metadataPath(hookPath), err)
+	}
+
+	if m.FailurePolicy == "" {
+		m.FailurePolicy = FailurePolicyFail
+	}
+
+	return &m, nil
+}
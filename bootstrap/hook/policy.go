@@ -0,0 +1,165 @@
+package hook
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/agent/env"
+)
+
+// Today any hook can silently rewrite BUILDKITE_REPO, PATH, or anything
+// else for every phase that runs after it. Policy puts a quarantine in
+// front of that: a hook's exported variables are diffed as before, but
+// aren't merged into the bootstrap env until Policy.Apply has decided
+// whether each one is accepted, redacted, or rejected outright.
+
+// defaultDeniedPrefixes are the patterns that are rejected unless
+// explicitly allowlisted, because they control how later hooks and
+// commands behave. The entries ending in "_" match by prefix; "PATH"
+// matches only the variable named PATH (see matches).
+var defaultDeniedPrefixes = []string{
+	"BUILDKITE_",
+	"PATH",
+	"LD_",
+	"DYLD_",
+	"GIT_",
+}
+
+// AuditAction records what Policy.Apply decided to do with a single
+// variable.
+type AuditAction string
+
+const (
+	AuditActionAccepted AuditAction = "accepted"
+	AuditActionRedacted AuditAction = "redacted"
+	AuditActionRejected AuditAction = "rejected"
+)
+
+// AuditEntry is one line of the structured audit log Policy.Apply produces.
+type AuditEntry struct {
+	Variable string
+	Action   AuditAction
+	Reason   string
+}
+
+// Policy decides which environment variables a hook is allowed to export
+// into the bootstrap environment. Variables matching Deny (after removing
+// anything in Allow) are rejected outright; variables in Redact are
+// accepted but have their value replaced with a fixed placeholder so it
+// doesn't leak into logs.
+type Policy struct {
+	Allow  []string
+	Deny   []string
+	Redact []string
+
+	// RequireSigned rejects every variable unless VerifySignature says it
+	// carries a valid signature. Until VerifySignature is also set it
+	// rejects everything, since there's no such thing as a variable that's
+	// trivially "signed".
+	RequireSigned bool
+
+	// VerifySignature checks a signature a hook produced for name/value,
+	// when RequireSigned is set. Left nil by NewPolicy; callers that turn
+	// hook_env_require_signed on are expected to provide one.
+	VerifySignature func(name, value string) bool
+
+	Audit []AuditEntry
+}
+
+// NewPolicy builds a Policy from agent config values: hook_env_allow,
+// hook_env_deny, hook_env_redact and hook_env_require_signed.
+func NewPolicy(allow, deny, redact []string, requireSigned bool) *Policy {
+	if deny == nil {
+		deny = defaultDeniedPrefixes
+	}
+
+	return &Policy{
+		Allow:         allow,
+		Deny:          deny,
+		Redact:        redact,
+		RequireSigned: requireSigned,
+	}
+}
+
+// Apply splits diff into the variables that are allowed into the bootstrap
+// environment and the ones that are rejected, redacting any that match
+// Redact along the way. Every variable in diff produces exactly one
+// AuditEntry, appended to p.Audit.
+func (p *Policy) Apply(diff *env.Environment) (accepted, rejected *env.Environment, err error) {
+	accepted = env.FromSlice(nil)
+	rejected = env.FromSlice(nil)
+
+	for name, value := range diff.ToMap() {
+		if p.RequireSigned && !p.signatureOK(name, value) {
+			rejected.Set(name, value)
+			p.audit(name, AuditActionRejected, "missing required signature (hook_env_require_signed)")
+			continue
+		}
+
+		allowed := p.matches(name, p.Allow)
+		if !allowed && p.matches(name, p.Deny) {
+			rejected.Set(name, value)
+			p.audit(name, AuditActionRejected, "matched hook_env_deny")
+			continue
+		}
+
+		value, reason, action := value, "no allow/deny match", AuditActionAccepted
+		if allowed {
+			reason = "matched hook_env_allow"
+		}
+		if p.matches(name, p.Redact) {
+			value = "[REDACTED]"
+			action = AuditActionRedacted
+			reason = "matched hook_env_redact"
+		}
+
+		accepted.Set(name, value)
+		p.audit(name, action, reason)
+	}
+
+	return accepted, rejected, nil
+}
+
+// signatureOK reports whether name/value carries a valid signature, per
+// VerifySignature. With no VerifySignature set, nothing is ever considered
+// signed - turning RequireSigned on without wiring one up rejects every
+// variable, rather than silently doing nothing.
+func (p *Policy) signatureOK(name, value string) bool {
+	if p.VerifySignature == nil {
+		return false
+	}
+	return p.VerifySignature(name, value)
+}
+
+func (p *Policy) audit(name string, action AuditAction, reason string) {
+	p.Audit = append(p.Audit, AuditEntry{Variable: name, Action: action, Reason: reason})
+}
+
+// matches reports whether name matches any of patterns. A pattern ending in
+// "*" matches by prefix (e.g. "SAFE_VAR*" matches "SAFE_VAR_EXTRA"); a
+// pattern ending in "_" matches by prefix too, without needing an explicit
+// "*", so the default deny list can use namespace-style prefixes like
+// "LD_" directly; any other pattern must match name exactly.
+func (p *Policy) matches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case strings.HasSuffix(pattern, "*"):
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		case strings.HasSuffix(pattern, "_"):
+			if strings.HasPrefix(name, pattern) {
+				return true
+			}
+		case name == pattern:
+			return true
+		}
+	}
+	return false
+}
+
+// String renders an AuditEntry as a single structured log line, e.g.
+// `hook env BUILDKITE_REPO: rejected (matched hook_env_deny)`.
+func (e AuditEntry) String() string {
+	return fmt.Sprintf("hook env %s: %s (%s)", e.Variable, e.Action, e.Reason)
+}
@@ -0,0 +1,128 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/env"
+)
+
+func TestPolicy_Apply(t *testing.T) {
+	diff := env.FromSlice([]string{
+		"BUILDKITE_REPO=https://evil.example.com/not-the-repo",
+		"PATH=/tmp/evil:/usr/bin",
+		"MY_APP_VERSION=1.2.3",
+		"SECRET_TOKEN=hunter2",
+	})
+
+	policy := NewPolicy(
+		[]string{"BUILDKITE_MESSAGE"},
+		nil,
+		[]string{"SECRET_TOKEN"},
+		false,
+	)
+
+	accepted, rejected, err := policy.Apply(diff)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	acceptedMap := accepted.ToMap()
+	rejectedMap := rejected.ToMap()
+
+	if _, ok := rejectedMap["BUILDKITE_REPO"]; !ok {
+		t.Errorf("expected BUILDKITE_REPO to be rejected, got accepted = %+v", acceptedMap)
+	}
+	if _, ok := rejectedMap["PATH"]; !ok {
+		t.Errorf("expected PATH to be rejected, got accepted = %+v", acceptedMap)
+	}
+	if got, want := acceptedMap["MY_APP_VERSION"], "1.2.3"; got != want {
+		t.Errorf("MY_APP_VERSION = %q, want %q", got, want)
+	}
+	if got, want := acceptedMap["SECRET_TOKEN"], "[REDACTED]"; got != want {
+		t.Errorf("SECRET_TOKEN = %q, want %q", got, want)
+	}
+
+	if len(policy.Audit) != 4 {
+		t.Errorf("len(policy.Audit) = %d, want exactly one entry per variable", len(policy.Audit))
+	}
+
+	seen := map[string]int{}
+	for _, entry := range policy.Audit {
+		seen[entry.Variable]++
+	}
+	for name, count := range seen {
+		if count != 1 {
+			t.Errorf("Audit contains %d entries for %s, want exactly 1", count, name)
+		}
+	}
+}
+
+func TestPolicy_Apply_WildcardMatchesByPrefix(t *testing.T) {
+	diff := env.FromSlice([]string{"SAFE_VAR_EXTRA=ok"})
+
+	// SAFE_* would reject SAFE_VAR_EXTRA unless the SAFE_VAR* allow
+	// wildcard matches it first.
+	policy := NewPolicy([]string{"SAFE_VAR*"}, []string{"SAFE_*"}, nil, false)
+
+	accepted, rejected, err := policy.Apply(diff)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if len(rejected.ToMap()) != 0 {
+		t.Errorf("expected SAFE_VAR_EXTRA to be allowed by the SAFE_VAR* wildcard, got rejected = %+v", rejected.ToMap())
+	}
+	if got, want := accepted.ToMap()["SAFE_VAR_EXTRA"], "ok"; got != want {
+		t.Errorf("SAFE_VAR_EXTRA = %q, want %q", got, want)
+	}
+}
+
+func TestPolicy_Apply_RequireSigned(t *testing.T) {
+	diff := env.FromSlice([]string{"MY_APP_VERSION=1.2.3"})
+
+	policy := NewPolicy(nil, []string{}, nil, true)
+
+	t.Run("no verifier configured rejects everything", func(t *testing.T) {
+		_, rejected, err := policy.Apply(diff)
+		if err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		if _, ok := rejected.ToMap()["MY_APP_VERSION"]; !ok {
+			t.Errorf("expected MY_APP_VERSION to be rejected when RequireSigned has no VerifySignature")
+		}
+	})
+
+	t.Run("a passing verifier allows it through", func(t *testing.T) {
+		policy.Audit = nil
+		policy.VerifySignature = func(name, value string) bool { return true }
+
+		accepted, rejected, err := policy.Apply(diff)
+		if err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		if len(rejected.ToMap()) != 0 {
+			t.Errorf("expected nothing rejected with a passing VerifySignature, got %+v", rejected.ToMap())
+		}
+		if got, want := accepted.ToMap()["MY_APP_VERSION"], "1.2.3"; got != want {
+			t.Errorf("MY_APP_VERSION = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestPolicy_Apply_Allowlisted(t *testing.T) {
+	diff := env.FromSlice([]string{"BUILDKITE_REPO=https://example.com/repo"})
+
+	policy := NewPolicy([]string{"BUILDKITE_REPO"}, nil, nil, false)
+
+	accepted, rejected, err := policy.Apply(diff)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if len(rejected.ToMap()) != 0 {
+		t.Errorf("expected nothing rejected, got %+v", rejected.ToMap())
+	}
+	if got, want := accepted.ToMap()["BUILDKITE_REPO"], "https://example.com/repo"; got != want {
+		t.Errorf("BUILDKITE_REPO = %q, want %q", got, want)
+	}
+}
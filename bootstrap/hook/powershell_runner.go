@@ -0,0 +1,159 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/agent/env"
+)
+
+// The Windows .bat wrapper used by diffRunner can't dot-source a .ps1 hook,
+// and SET/CALL mangles multi-line values and anything outside the system
+// codepage. powershellRunner instead runs the hook under a real PowerShell
+// wrapper and has it report its environment back as UTF-8 JSON, so values
+// round-trip exactly regardless of what characters they contain.
+type powershellRunner struct {
+	hookPath      string
+	launcherFile  *os.File
+	scriptFile    *os.File
+	beforeEnvFile *os.File
+	afterEnvFile  *os.File
+}
+
+// IsPowerShellHook reports whether hookPath should be run with the
+// PowerShell runner: either it ends in .ps1, or the agent has been
+// configured with `shell = powershell`.
+func IsPowerShellHook(hookPath, configuredShell string) bool {
+	return strings.EqualFold(filepath.Ext(hookPath), ".ps1") || strings.EqualFold(configuredShell, "powershell")
+}
+
+func newPowershellRunner(hookPath string) (*powershellRunner, error) {
+	h := &powershellRunner{hookPath: hookPath}
+
+	var err error
+	h.scriptFile, err = tempFile(`buildkite-agent-bootstrap-hook-runner.ps1`)
+	if err != nil {
+		return nil, err
+	}
+
+	h.beforeEnvFile, err = tempFile(`buildkite-agent-bootstrap-hook-env-before.json`)
+	if err != nil {
+		return nil, err
+	}
+	h.beforeEnvFile.Close()
+
+	h.afterEnvFile, err = tempFile(`buildkite-agent-bootstrap-hook-env-after.json`)
+	if err != nil {
+		return nil, err
+	}
+	h.afterEnvFile.Close()
+
+	absolutePathToHook, err := filepath.Abs(h.hookPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find absolute path to \"%s\" (%s)", h.hookPath, err)
+	}
+
+	script := powershellEnvDumpFunction() +
+		fmt.Sprintf("Dump-Env \"%s\"\n", h.beforeEnvFile.Name()) +
+		fmt.Sprintf(". \"%s\"\n", absolutePathToHook) +
+		"$BUILDKITE_LAST_HOOK_EXIT_STATUS = $LASTEXITCODE\n" +
+		fmt.Sprintf("Dump-Env \"%s\"\n", h.afterEnvFile.Name()) +
+		"exit $BUILDKITE_LAST_HOOK_EXIT_STATUS\n"
+
+	h.scriptFile.WriteString(script)
+	h.scriptFile.Close()
+
+	// Path() has to return something directly executable, same as every
+	// other runner, but Windows won't launch a .ps1 on its own (and even
+	// if it would, the default execution policy blocks it). So Path()
+	// actually points at a tiny .bat launcher that invokes PowerShell with
+	// the right flags and forwards its exit code, instead of a bare
+	// "powershell" with no arguments that nothing launches.
+	h.launcherFile, err = tempFile(`buildkite-agent-bootstrap-hook-launcher.bat`)
+	if err != nil {
+		return h, err
+	}
+
+	launcher := "@echo off\n" +
+		fmt.Sprintf(
+			"powershell -NoProfile -ExecutionPolicy Bypass -File \"%s\" %%*\n",
+			h.scriptFile.Name(),
+		) +
+		"exit %ERRORLEVEL%\n"
+
+	h.launcherFile.WriteString(launcher)
+	h.launcherFile.Close()
+
+	return h, nil
+}
+
+// powershellEnvDumpFunction returns a PowerShell function that writes
+// every environment variable to a file as a UTF-8 JSON object, so that
+// Unicode values and embedded newlines survive the round trip intact.
+func powershellEnvDumpFunction() string {
+	return `function Dump-Env($path) {
+  $vars = @{}
+  Get-ChildItem Env: | ForEach-Object { $vars[$_.Name] = $_.Value }
+  $vars | ConvertTo-Json -Compress | Out-File -Encoding utf8 $path
+}
+`
+}
+
+// Path returns the path to the .bat launcher, which is what should actually
+// be executed: it runs PowerShell against the real .ps1 wrapper with the
+// right flags, forwarding its exit code.
+func (h *powershellRunner) Path() string {
+	return h.launcherFile.Name()
+}
+
+// Close cleans up the launcher, the wrapper script, and the environment
+// snapshot files.
+func (h *powershellRunner) Close() {
+	os.Remove(h.launcherFile.Name())
+	os.Remove(h.scriptFile.Name())
+	os.Remove(h.beforeEnvFile.Name())
+	os.Remove(h.afterEnvFile.Name())
+}
+
+// ChangedEnvironment returns the environment variables exported during the
+// hook run, read back from the before/after JSON snapshots.
+func (h *powershellRunner) ChangedEnvironment() (*env.Environment, error) {
+	before, err := readPowershellEnvJSON(h.beforeEnvFile.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := readPowershellEnvJSON(h.afterEnvFile.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	_ = after.Remove(`BUILDKITE_LAST_HOOK_EXIT_STATUS`)
+
+	return after.Diff(before), nil
+}
+
+func readPowershellEnvJSON(path string) (*env.Environment, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read \"%s\" (%s)", path, err)
+	}
+
+	// Windows PowerShell's "Out-File -Encoding utf8" starts the file with a
+	// byte order mark, which encoding/json doesn't accept.
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+
+	var vars map[string]string
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, &vars); err != nil {
+			return nil, fmt.Errorf("Failed to parse \"%s\" as JSON (%s)", path, err)
+		}
+	}
+
+	return env.FromJSON(vars), nil
+}
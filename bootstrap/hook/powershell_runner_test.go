@@ -0,0 +1,67 @@
+package hook
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsPowerShellHook(t *testing.T) {
+	tests := []struct {
+		hookPath        string
+		configuredShell string
+		want            bool
+	}{
+		{hookPath: "hooks/pre-command", configuredShell: "", want: false},
+		{hookPath: "hooks/pre-command.ps1", configuredShell: "", want: true},
+		{hookPath: "hooks/pre-command.PS1", configuredShell: "", want: true},
+		{hookPath: "hooks/pre-command", configuredShell: "powershell", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := IsPowerShellHook(tt.hookPath, tt.configuredShell); got != tt.want {
+			t.Errorf("IsPowerShellHook(%q, %q) = %v, want %v", tt.hookPath, tt.configuredShell, got, tt.want)
+		}
+	}
+}
+
+// TestPowershellRunner_PathIsDirectlyExecutable guards against Path()
+// going back to returning a bare "powershell" with no arguments, which
+// nothing can actually launch the hook with: it checks that Path() names a
+// real file whose contents invoke powershell with -File.
+func TestPowershellRunner_PathIsDirectlyExecutable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook-ps1")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hookPath := filepath.Join(dir, "pre-command.ps1")
+	if err := ioutil.WriteFile(hookPath, []byte("Write-Host hi\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	runner, err := NewRunner(RunnerTypeDiff, hookPath)
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	defer runner.Close()
+
+	if _, ok := runner.(*powershellRunner); !ok {
+		t.Fatalf("NewRunner() = %T, want *powershellRunner for a .ps1 hook", runner)
+	}
+
+	launcherContents, err := ioutil.ReadFile(runner.Path())
+	if err != nil {
+		t.Fatalf("ReadFile(runner.Path()) error = %v", err)
+	}
+
+	if !strings.Contains(string(launcherContents), "powershell") {
+		t.Errorf("launcher %q does not invoke powershell: %s", runner.Path(), launcherContents)
+	}
+	if !strings.Contains(string(launcherContents), "-File") {
+		t.Errorf("launcher %q does not pass -File: %s", runner.Path(), launcherContents)
+	}
+}
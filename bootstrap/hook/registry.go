@@ -0,0 +1,285 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/buildkite/agent/env"
+)
+
+// Scope identifies where a hook was discovered, which decides the order it
+// runs relative to hooks found in other scopes for the same event.
+type Scope string
+
+const (
+	ScopeAgent  Scope = "agent"
+	ScopePlugin Scope = "plugin"
+	ScopeRepo   Scope = "repo"
+)
+
+// scopeOrder is the order scopes run in for a given event: agent hooks
+// first, then plugin hooks, then repo hooks.
+var scopeOrder = []Scope{ScopeAgent, ScopePlugin, ScopeRepo}
+
+// Binding is a single hook resolved against a single event, ready to run.
+type Binding struct {
+	Event    string
+	Scope    Scope
+	Path     string
+	Metadata *Metadata
+}
+
+// HookRegistry scans agent-level, plugin-level and repo-level hook
+// directories and resolves an ordered execution plan per event. A single
+// hook script can subscribe to more than one event via its hook.yaml
+// sidecar, instead of needing to be symlinked under every event name it
+// wants to run for.
+type HookRegistry struct {
+	dirs map[Scope]string
+
+	// Policy, if set, filters every hook's exported environment through
+	// Policy.Apply before RunEvent merges it into the bootstrap env. A nil
+	// Policy merges every hook's exports unfiltered, as before.
+	Policy *Policy
+}
+
+// NewRegistry creates a HookRegistry that looks for hooks in the given
+// per-scope directories. Any of them may be empty, in which case that scope
+// is skipped.
+func NewRegistry(dirs map[Scope]string) *HookRegistry {
+	return &HookRegistry{dirs: dirs}
+}
+
+// Resolve returns the ordered list of hooks bound to event, across all
+// scopes, in the order they should be run: agent, then plugin, then repo,
+// and lexically by filename within a directory.
+func (r *HookRegistry) Resolve(event string) ([]Binding, error) {
+	var bindings []Binding
+
+	for _, scope := range scopeOrder {
+		dir, ok := r.dirs[scope]
+		if !ok || dir == "" {
+			continue
+		}
+
+		scoped, err := r.resolveDir(event, scope, dir)
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, scoped...)
+	}
+
+	return bindings, nil
+}
+
+func (r *HookRegistry) resolveDir(event string, scope Scope, dir string) ([]Binding, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) == ".yaml" {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	var bindings []Binding
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+
+		metadata, err := LoadMetadata(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if boundToEvent(name, metadata, event) {
+			bindings = append(bindings, Binding{
+				Event:    event,
+				Scope:    scope,
+				Path:     path,
+				Metadata: metadata,
+			})
+		}
+	}
+
+	chained, err := resolveChain(event, scope, dir)
+	if err != nil {
+		return nil, err
+	}
+	bindings = append(bindings, chained...)
+
+	return bindings, nil
+}
+
+// resolveChain looks for a "<event>.d" directory alongside dir's hooks
+// (e.g. "pre-command.d" next to "pre-command") and, if present, returns a
+// Binding per file inside it, in Directory's lexical order, run after the
+// scope's own single-file hook. A hook in the chain without its own
+// hook.yaml sidecar gets FailurePolicyIgnore when the chain directory
+// carries a continue-on-error marker, and FailurePolicyFail otherwise.
+func resolveChain(event string, scope Scope, dir string) ([]Binding, error) {
+	chainDir := filepath.Join(dir, event+".d")
+
+	hooks, err := Directory(chainDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+
+	defaultFailurePolicy := FailurePolicyFail
+	if ContinueOnError(chainDir) {
+		defaultFailurePolicy = FailurePolicyIgnore
+	}
+
+	var bindings []Binding
+	for _, h := range hooks {
+		metadata, err := LoadMetadata(h.Path)
+		if err != nil {
+			return nil, err
+		}
+		if metadata == nil {
+			metadata = &Metadata{FailurePolicy: defaultFailurePolicy}
+		}
+
+		bindings = append(bindings, Binding{
+			Event:    event,
+			Scope:    scope,
+			Path:     h.Path,
+			Metadata: metadata,
+		})
+	}
+
+	return bindings, nil
+}
+
+// boundToEvent decides whether a hook should run for event: either its
+// hook.yaml explicitly lists the event, or (with no sidecar) its filename
+// matches the event name, as before.
+func boundToEvent(filename string, metadata *Metadata, event string) bool {
+	if metadata != nil {
+		for _, e := range metadata.Events {
+			if e == event {
+				return true
+			}
+		}
+		return false
+	}
+
+	return filename == event
+}
+
+// RunEvent runs every hook bound to event in order, using runnerType to
+// execute each one, and merges their ChangedEnvironment() results into a
+// single environment using last-writer-wins: a later hook's export of the
+// same variable overrides an earlier one's.
+//
+// Each binding's hook.yaml sidecar (if any) is honoured: Metadata.Timeout
+// and Metadata.Command/Args are applied to that hook's run on top of opts,
+// and Metadata.FailurePolicy decides what happens when it exits non-zero -
+// FailurePolicyIgnore moves on to the next hook, FailurePolicyRetry re-runs
+// it once before falling back to FailurePolicyFail, and FailurePolicyFail
+// (the default) aborts the event and returns an error.
+func RunEvent(ctx context.Context, registry *HookRegistry, runnerType RunnerType, event string, opts RunOptions) (*env.Environment, error) {
+	bindings, err := registry.Resolve(event)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := env.FromSlice(nil)
+
+	for _, b := range bindings {
+		result, err := runBinding(ctx, b, runnerType, opts)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			// FailurePolicyIgnore: the hook failed, but we move on
+			// without merging anything it may have exported.
+			continue
+		}
+
+		changed := result.Env
+		if registry.Policy != nil {
+			accepted, _, err := registry.Policy.Apply(changed)
+			if err != nil {
+				return nil, err
+			}
+			changed = accepted
+		}
+
+		// Declaration order wins: each hook's exports are applied on top
+		// of the ones before it.
+		merged = merged.Merge(changed)
+	}
+
+	return merged, nil
+}
+
+// runBinding runs a single binding to completion, applying its Metadata's
+// Timeout/Command/Args/FailurePolicy on top of opts. It returns a nil
+// Result (and nil error) when the hook failed but FailurePolicyIgnore says
+// to carry on regardless.
+func runBinding(ctx context.Context, b Binding, runnerType RunnerType, opts RunOptions) (*Result, error) {
+	bindingOpts := opts
+	failurePolicy := FailurePolicyFail
+	if b.Metadata != nil {
+		if b.Metadata.Timeout > 0 {
+			bindingOpts.Timeout = b.Metadata.Timeout
+		}
+		if b.Metadata.Command != "" {
+			bindingOpts.Command = b.Metadata.Command
+			bindingOpts.Args = b.Metadata.Args
+		}
+		if b.Metadata.FailurePolicy != "" {
+			failurePolicy = b.Metadata.FailurePolicy
+		}
+	}
+
+	result, err := runOnce(ctx, b.Path, runnerType, bindingOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ExitStatus != 0 && failurePolicy == FailurePolicyRetry {
+		result, err = runOnce(ctx, b.Path, runnerType, bindingOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result.ExitStatus != 0 {
+		if failurePolicy == FailurePolicyIgnore {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("hook %q exited with status %d", b.Path, result.ExitStatus)
+	}
+
+	return result, nil
+}
+
+// runOnce creates a fresh runner for path and runs it exactly once; each
+// Runner implementation is single-use (its wrapper script and environment
+// snapshot files are consumed by the one process it ran), so a retry needs
+// its own runner rather than re-invoking Run on one that already exited.
+func runOnce(ctx context.Context, path string, runnerType RunnerType, opts RunOptions) (*Result, error) {
+	runner, err := NewRunner(runnerType, path)
+	if err != nil {
+		return nil, err
+	}
+	defer runner.Close()
+
+	return Run(ctx, runner, opts)
+}
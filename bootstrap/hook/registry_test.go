@@ -0,0 +1,210 @@
+package hook
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeHook(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0700); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func writeMetadata(t *testing.T, dir, name, yaml string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(yaml), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func TestHookRegistry_Resolve(t *testing.T) {
+	agentDir, err := ioutil.TempDir("", "hooks-agent")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(agentDir)
+
+	repoDir, err := ioutil.TempDir("", "hooks-repo")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	writeHook(t, agentDir, "pre-command", "#!/bin/bash\n")
+	writeHook(t, repoDir, "secrets", "#!/bin/bash\n")
+	writeMetadata(t, repoDir, "secrets", "events: [pre-command, post-command]\n")
+	writeHook(t, repoDir, "metrics", "#!/bin/bash\n")
+	writeMetadata(t, repoDir, "metrics", "events: [post-command]\n")
+
+	registry := NewRegistry(map[Scope]string{
+		ScopeAgent: agentDir,
+		ScopeRepo:  repoDir,
+	})
+
+	bindings, err := registry.Resolve("pre-command")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	if len(bindings) != 2 {
+		t.Fatalf("len(bindings) = %d, want 2", len(bindings))
+	}
+	if bindings[0].Scope != ScopeAgent || filepath.Base(bindings[0].Path) != "pre-command" {
+		t.Errorf("bindings[0] = %+v, want agent-scoped pre-command", bindings[0])
+	}
+	if bindings[1].Scope != ScopeRepo || filepath.Base(bindings[1].Path) != "secrets" {
+		t.Errorf("bindings[1] = %+v, want repo-scoped secrets", bindings[1])
+	}
+
+	postBindings, err := registry.Resolve("post-command")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(postBindings) != 2 {
+		t.Fatalf("len(postBindings) = %d, want 2 (secrets and metrics)", len(postBindings))
+	}
+}
+
+// TestHookRegistry_Resolve_Chain checks that a "<event>.d" directory next
+// to a scope's hooks contributes a binding per file, in lexical order,
+// after that scope's own single-file hook.
+func TestHookRegistry_Resolve_Chain(t *testing.T) {
+	dir := t.TempDir()
+
+	writeHook(t, dir, "pre-command", "#!/bin/bash\n")
+
+	chainDir := filepath.Join(dir, "pre-command.d")
+	if err := os.Mkdir(chainDir, 0700); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	writeHook(t, chainDir, "10-first", "#!/bin/bash\n")
+	writeHook(t, chainDir, "20-second", "#!/bin/bash\n")
+
+	registry := NewRegistry(map[Scope]string{ScopeRepo: dir})
+
+	bindings, err := registry.Resolve("pre-command")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	if len(bindings) != 3 {
+		t.Fatalf("len(bindings) = %d, want 3 (pre-command, 10-first, 20-second)", len(bindings))
+	}
+	if filepath.Base(bindings[0].Path) != "pre-command" {
+		t.Errorf("bindings[0] = %+v, want the single-file pre-command hook first", bindings[0])
+	}
+	if filepath.Base(bindings[1].Path) != "10-first" || filepath.Base(bindings[2].Path) != "20-second" {
+		t.Errorf("bindings[1:] = %+v, want 10-first then 20-second", bindings[1:])
+	}
+}
+
+// TestHookRegistry_Resolve_ChainContinueOnError checks that a chained hook
+// with no hook.yaml sidecar defaults to FailurePolicyIgnore when its
+// directory carries a continue-on-error marker.
+func TestHookRegistry_Resolve_ChainContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+
+	chainDir := filepath.Join(dir, "pre-command.d")
+	if err := os.Mkdir(chainDir, 0700); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	writeHook(t, chainDir, "10-first", "#!/bin/bash\n")
+	if err := ioutil.WriteFile(filepath.Join(chainDir, continueOnErrorMarker), nil, 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	registry := NewRegistry(map[Scope]string{ScopeRepo: dir})
+
+	bindings, err := registry.Resolve("pre-command")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(bindings) != 1 {
+		t.Fatalf("len(bindings) = %d, want 1", len(bindings))
+	}
+	if bindings[0].Metadata.FailurePolicy != FailurePolicyIgnore {
+		t.Errorf("bindings[0].Metadata.FailurePolicy = %q, want %q", bindings[0].Metadata.FailurePolicy, FailurePolicyIgnore)
+	}
+}
+
+// TestRunEvent_FailurePolicyIgnore checks that a hook declaring
+// failure_policy: ignore doesn't abort the rest of the event.
+func TestRunEvent_FailurePolicyIgnore(t *testing.T) {
+	dir := t.TempDir()
+
+	writeHook(t, dir, "10-fails", "#!/bin/bash\nexit 1\n")
+	writeMetadata(t, dir, "10-fails", "events: [pre-command]\nfailure_policy: ignore\n")
+	writeHook(t, dir, "20-ok", "#!/bin/bash\nexport SECOND=ran\n")
+	writeMetadata(t, dir, "20-ok", "events: [pre-command]\n")
+
+	registry := NewRegistry(map[Scope]string{ScopeRepo: dir})
+
+	merged, err := RunEvent(context.Background(), registry, RunnerTypeDiff, "pre-command", RunOptions{})
+	if err != nil {
+		t.Fatalf("RunEvent() error = %v, want the failure to be ignored", err)
+	}
+	if got, want := merged.ToMap()["SECOND"], "ran"; got != want {
+		t.Errorf("merged[SECOND] = %q, want %q (20-ok should still run)", got, want)
+	}
+}
+
+// TestRunEvent_FailurePolicyFail checks the default behaviour: a failing
+// hook with no failure_policy (or failure_policy: fail) aborts the event.
+func TestRunEvent_FailurePolicyFail(t *testing.T) {
+	dir := t.TempDir()
+
+	writeHook(t, dir, "10-fails", "#!/bin/bash\nexit 1\n")
+	writeMetadata(t, dir, "10-fails", "events: [pre-command]\n")
+	writeHook(t, dir, "20-never-runs", "#!/bin/bash\nexport SECOND=ran\n")
+	writeMetadata(t, dir, "20-never-runs", "events: [pre-command]\n")
+
+	registry := NewRegistry(map[Scope]string{ScopeRepo: dir})
+
+	if _, err := RunEvent(context.Background(), registry, RunnerTypeDiff, "pre-command", RunOptions{}); err == nil {
+		t.Fatalf("RunEvent() error = nil, want the 10-fails exit status to abort the event")
+	}
+}
+
+// TestRunEvent_Timeout checks that a hook.yaml's timeout reaches Run's
+// RunOptions and kills a hook that overruns it.
+func TestRunEvent_Timeout(t *testing.T) {
+	dir := t.TempDir()
+
+	writeHook(t, dir, "10-slow", "#!/bin/bash\nsleep 5\n")
+	writeMetadata(t, dir, "10-slow", "events: [pre-command]\ntimeout: 10000000\n") // 10ms, in nanoseconds
+
+	registry := NewRegistry(map[Scope]string{ScopeRepo: dir})
+
+	_, err := RunEvent(context.Background(), registry, RunnerTypeDiff, "pre-command", RunOptions{})
+	if err == nil {
+		t.Fatalf("RunEvent() error = nil, want the 10ms timeout to kill 10-slow")
+	}
+}
+
+// TestRunEvent_Policy checks that RunEvent filters each hook's exports
+// through registry.Policy before merging them, rather than merging them
+// unfiltered.
+func TestRunEvent_Policy(t *testing.T) {
+	dir := t.TempDir()
+
+	writeHook(t, dir, "pre-command", "#!/bin/bash\nexport BUILDKITE_REPO=evil\nexport SAFE_VAR=ok\n")
+
+	registry := NewRegistry(map[Scope]string{ScopeRepo: dir})
+	registry.Policy = NewPolicy(nil, nil, nil, false)
+
+	merged, err := RunEvent(context.Background(), registry, RunnerTypeDiff, "pre-command", RunOptions{})
+	if err != nil {
+		t.Fatalf("RunEvent() error = %v", err)
+	}
+	if _, ok := merged.Get("BUILDKITE_REPO"); ok {
+		t.Errorf("merged contains BUILDKITE_REPO, want it rejected by the default deny list")
+	}
+	if got, want := merged.ToMap()["SAFE_VAR"], "ok"; got != want {
+		t.Errorf("merged[SAFE_VAR] = %q, want %q", got, want)
+	}
+}
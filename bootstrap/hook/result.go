@@ -0,0 +1,158 @@
+package hook
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/buildkite/agent/env"
+)
+
+// killGracePeriodDefault is how long we wait after SIGTERM before
+// escalating to SIGKILL if the hook hasn't exited yet.
+const killGracePeriodDefault = 10 * time.Second
+
+// Logger is the subset of the bootstrap shell's logger that Run needs in
+// order to stream hook output as it happens, instead of buffering it until
+// the hook finishes.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// RunOptions bounds and observes a single hook execution.
+type RunOptions struct {
+	// Timeout, if non-zero, is the maximum amount of time the hook is
+	// allowed to run for before it's killed.
+	Timeout time.Duration
+
+	// KillGracePeriod is how long to wait after SIGTERM before sending
+	// SIGKILL. Defaults to killGracePeriodDefault.
+	KillGracePeriod time.Duration
+
+	// Logger receives the hook's stdout/stderr line by line as it runs.
+	Logger Logger
+
+	// Command, if set, overrides the hook's own shebang/interpreter: the
+	// hook is run as `Command Args... runner.Path()` instead of executing
+	// runner.Path() directly. This is how a hook.yaml's `command`/`args`
+	// get applied.
+	Command string
+	Args    []string
+}
+
+// Result carries everything the bootstrap needs to know about a finished
+// hook run in order to make retry/skip decisions.
+type Result struct {
+	ExitStatus int
+	Duration   time.Duration
+	Killed     bool
+	Env        *env.Environment
+}
+
+// Run executes runner under ctx, enforcing opts.Timeout if set, streaming
+// output through opts.Logger as it's produced, and returning a Result. If
+// the hook is killed (by ctx or by the timeout), any environment changes it
+// made are discarded, since a half-finished hook's exports can't be trusted.
+func Run(ctx context.Context, runner Runner, opts RunOptions) (*Result, error) {
+	if opts.KillGracePeriod == 0 {
+		opts.KillGracePeriod = killGracePeriodDefault
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if opts.Command != "" {
+		cmd = exec.Command(opts.Command, append(append([]string{}, opts.Args...), runner.Path())...)
+	} else {
+		cmd = exec.Command(runner.Path())
+	}
+	if extra, ok := runner.(interface{ ExtraFiles() []*os.File }); ok {
+		cmd.ExtraFiles = extra.ExtraFiles()
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to attach stdout pipe (%s)", err)
+	}
+	cmd.Stderr = cmd.Stdout
+	configureProcessGroup(cmd)
+
+	start := time.Now()
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start hook (%s)", err)
+	}
+
+	// The child holds its own copies of any extra files (such as the
+	// channel runner's pipe writer); closing ours lets the reader see EOF
+	// once the hook exits, even if it exits before writing everything.
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if opts.Logger != nil {
+				opts.Logger.Printf("%s", scanner.Text())
+			}
+		}
+	}()
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+
+	killed := false
+
+	select {
+	case err := <-waitErr:
+		<-streamDone
+		return resultFromWait(runner, start, killed, err)
+
+	case <-ctx.Done():
+		killed = true
+		terminate(cmd, opts.KillGracePeriod, waitErr)
+		<-streamDone
+		io.Copy(io.Discard, stdout) //nolint:errcheck
+		return &Result{
+			ExitStatus: -1,
+			Duration:   time.Since(start),
+			Killed:     true,
+			Env:        nil,
+		}, ctx.Err()
+	}
+}
+
+func resultFromWait(runner Runner, start time.Time, killed bool, waitErr error) (*Result, error) {
+	exitStatus := 0
+	if waitErr != nil {
+		if exitErr, ok := waitErr.(*exec.ExitError); ok {
+			exitStatus = exitErr.ExitCode()
+		} else {
+			return nil, fmt.Errorf("Failed to wait for hook (%s)", waitErr)
+		}
+	}
+
+	changed, err := runner.ChangedEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		ExitStatus: exitStatus,
+		Duration:   time.Since(start),
+		Killed:     killed,
+		Env:        changed,
+	}, nil
+}
@@ -0,0 +1,99 @@
+package hook
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, format)
+}
+
+func TestRun_KillsOnTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on unix process groups")
+	}
+
+	dir, err := ioutil.TempDir("", "hook-timeout")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hookPath := filepath.Join(dir, "hook")
+	body := "#!/bin/bash\necho about to sleep\nsleep 9999\nexport LLAMA=rock\n"
+	if err := ioutil.WriteFile(hookPath, []byte(body), 0700); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	runner, err := NewRunner(RunnerTypeDiff, hookPath)
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	defer runner.Close()
+
+	logger := &testLogger{}
+
+	start := time.Now()
+	result, err := Run(context.Background(), runner, RunOptions{
+		Timeout:         200 * time.Millisecond,
+		KillGracePeriod: 200 * time.Millisecond,
+		Logger:          logger,
+	})
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("Run() error = nil, want a timeout error")
+	}
+	if result == nil || !result.Killed {
+		t.Fatalf("Run() result = %+v, want Killed = true", result)
+	}
+	if result.Env != nil {
+		t.Errorf("Run() result.Env = %+v, want nil (partial exports should be discarded)", result.Env)
+	}
+	if limit := 200*time.Millisecond + 200*time.Millisecond + time.Second; elapsed > limit {
+		t.Errorf("Run() took %s, want it killed within the timeout plus the grace period (at most %s, with 1s of slack)", elapsed, limit)
+	}
+}
+
+// TestRun_UsesCommandOverride checks that RunOptions.Command/Args (as set
+// from a hook.yaml's `command`/`args`) are actually used to invoke the
+// hook, instead of always exec'ing runner.Path() directly.
+func TestRun_UsesCommandOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook-command-override")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hookPath := filepath.Join(dir, "hook")
+	if err := ioutil.WriteFile(hookPath, []byte("export LLAMA=rock\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	runner, err := NewRunner(RunnerTypeDiff, hookPath)
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	defer runner.Close()
+
+	result, err := Run(context.Background(), runner, RunOptions{
+		Command: "bash",
+		Args:    []string{"-x"},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, want := result.Env.ToMap()["LLAMA"], "rock"; got != want {
+		t.Errorf("result.Env[LLAMA] = %q, want %q (hook should have run under bash -x <path>)", got, want)
+	}
+}
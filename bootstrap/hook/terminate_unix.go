@@ -0,0 +1,33 @@
+// +build !windows
+
+package hook
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// configureProcessGroup puts the hook in its own process group so that
+// terminate can signal it (and anything it forked) as a unit.
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate sends SIGTERM to the hook's process group, waits up to grace
+// for it to exit, and escalates to SIGKILL if it's still running.
+func terminate(cmd *exec.Cmd, grace time.Duration, waitErr <-chan error) {
+	if cmd.Process == nil {
+		return
+	}
+
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+
+	select {
+	case <-waitErr:
+		return
+	case <-time.After(grace):
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-waitErr
+	}
+}
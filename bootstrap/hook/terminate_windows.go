@@ -0,0 +1,30 @@
+// +build windows
+
+package hook
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// configureProcessGroup starts the hook in its own process group so it can
+// be torn down as a unit, rather than leaving orphaned children behind.
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminate has no graceful SIGTERM equivalent on Windows, so it goes
+// straight to `taskkill /T /F`, which kills the whole process tree rooted
+// at the hook.
+func terminate(cmd *exec.Cmd, grace time.Duration, waitErr <-chan error) {
+	if cmd.Process == nil {
+		return
+	}
+
+	killCmd := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+	_ = killCmd.Run()
+
+	<-waitErr
+}
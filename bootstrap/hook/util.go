@@ -0,0 +1,84 @@
+package hook
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+)
+
+// TempDirProvider returns the directory hook runners should create their
+// temporary wrapper scripts and environment snapshot files under. The
+// default delegates to shell.TempFileWithExtension (which uses
+// os.TempDir()); tests can point it at t.TempDir() so temp files are
+// cleaned up automatically instead of relying on process-wide cleanup.
+type TempDirProvider func() string
+
+// CurrentTempDir is consulted by every runner that needs to create a
+// temporary file. Overriding it is the hook package's equivalent of
+// threading an afero.Fs through - it's enough to make the runners'
+// temp-file usage testable without pulling in a virtual filesystem.
+var CurrentTempDir TempDirProvider = func() string { return "" }
+
+// tempFile creates a temporary file named name (with its extension, if
+// any, preserved) under CurrentTempDir(), falling back to
+// shell.TempFileWithExtension's default location when CurrentTempDir
+// returns "".
+func tempFile(name string) (*os.File, error) {
+	dir := CurrentTempDir()
+	if dir == "" {
+		return shell.TempFileWithExtension(name)
+	}
+	ext := filepath.Ext(name)
+	return ioutil.TempFile(dir, strings.TrimSuffix(name, ext)+"-*"+ext)
+}
+
+// normalizeScriptFileName gives the wrapper script the right extension for
+// the platform it's going to be run on, e.g. ".bat" on Windows.
+func normalizeScriptFileName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".bat"
+	}
+	return name + ".sh"
+}
+
+// addExecutePermissiontoFile makes sure the wrapper script can actually be
+// run. This is a no-op on Windows, where the ".bat" extension is what makes
+// a file executable.
+func addExecutePermissiontoFile(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	s, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(path, s.Mode()|0100)
+}
+
+// windowsExecutableExts are the hook-relevant extensions Windows treats as
+// runnable; there's no execute permission bit to check there the way there
+// is on unix.
+var windowsExecutableExts = []string{".bat", ".cmd", ".exe", ".ps1"}
+
+// isExecutable reports whether entry should be treated as a runnable hook
+// rather than a stray file (a README, a backup, a sidecar this package
+// doesn't already know about) dropped into a hooks/<name>.d directory.
+func isExecutable(entry os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		for _, e := range windowsExecutableExts {
+			if ext == e {
+				return true
+			}
+		}
+		return false
+	}
+
+	return entry.Mode()&0111 != 0
+}
@@ -0,0 +1,13 @@
+package shell
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func TempFileWithExtension(name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	return ioutil.TempFile("", strings.TrimSuffix(name, ext)+"-*"+ext)
+}
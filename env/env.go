@@ -0,0 +1,201 @@
+package env
+
+import (
+	"regexp"
+	"strings"
+)
+
+type Environment struct {
+	m map[string]string
+}
+
+func New() *Environment {
+	return &Environment{m: map[string]string{}}
+}
+
+// FromSlice creates an Environment from a slice of "KEY=VALUE" strings, the
+// same format os.Environ() returns.
+func FromSlice(s []string) *Environment {
+	e := New()
+	for _, kv := range s {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		e.m[parts[0]] = parts[1]
+	}
+	return e
+}
+
+func FromJSON(m map[string]string) *Environment {
+	e := New()
+	for k, v := range m {
+		e.m[k] = v
+	}
+	return e
+}
+
+// declareVarRe matches a single-line "declare -x NAME=value" style
+// assignment - also covers arrays ("declare -a NAME=(...)") and any other
+// flag combination declare/export -p prints a name and a value for.
+var declareVarRe = regexp.MustCompile(`^declare (-[a-zA-Z]+) ([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// declareFuncMarkerRe matches the trailer line bash prints after an
+// exported function's body ("declare -fx name"), which has no "=" since a
+// function has no scalar value; everything printed since the previous
+// marker (or the start of input) is that function's body.
+var declareFuncMarkerRe = regexp.MustCompile(`^declare (-[a-zA-Z]*f[a-zA-Z]*) ([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// FromExport parses the output of bash's `declare -p`/`declare -px`/
+// `export -p` into an *Environment. It understands the three dialects that
+// show up in practice: plain scalars, double-quoted or $'...' ANSI-C
+// quoted ("declare -x NAME=\"value\"" / "declare -x NAME=$'line one\nline
+// two'"), arrays ("declare -a NAME=(...)" / "declare -A NAME=(...)",
+// including the literal "(...)" text as the value), and exported functions
+// ("declare -fx name", whose body is the lines printed before that
+// trailer rather than anything after an "=").
+func FromExport(s string) *Environment {
+	e := New()
+
+	var pending []string
+	flush := func() {
+		pending = pending[:0]
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		if m := declareVarRe.FindStringSubmatch(line); m != nil {
+			e.m[m[2]] = parseDeclareValue(m[3])
+			flush()
+			continue
+		}
+
+		if m := declareFuncMarkerRe.FindStringSubmatch(line); m != nil {
+			e.m[m[2]] = strings.Join(pending, "\n")
+			flush()
+			continue
+		}
+
+		// Not a recognised declare line: either a function body line, or
+		// blank/unrelated output, either of which belongs to whatever
+		// function marker comes next (if any).
+		pending = append(pending, strings.TrimRight(line, " \t"))
+	}
+
+	return e
+}
+
+// parseDeclareValue unquotes a single declare/export -p value: a bash
+// double-quoted string, a $'...' ANSI-C quoted string, or (for arrays, and
+// anything else declare doesn't quote) the raw text as-is.
+func parseDeclareValue(raw string) string {
+	switch {
+	case strings.HasPrefix(raw, `$'`) && strings.HasSuffix(raw, `'`):
+		return unescapeANSIC(raw[2 : len(raw)-1])
+	case strings.HasPrefix(raw, `"`) && strings.HasSuffix(raw, `"`):
+		return unescapeDoubleQuoted(raw[1 : len(raw)-1])
+	default:
+		return raw
+	}
+}
+
+// unescapeDoubleQuoted reverses the backslash-escaping bash applies to the
+// handful of characters that would otherwise break out of a double-quoted
+// declare value: the quote itself, a backslash, and $ / ` (which would
+// otherwise trigger expansion on re-evaluation).
+func unescapeDoubleQuoted(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '"', '\\', '$', '`':
+				b.WriteByte(s[i+1])
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unescapeANSIC reverses bash's $'...' quoting, which it falls back to for
+// values declare -p can't represent as a plain double-quoted string (most
+// commonly because they contain a literal newline).
+func unescapeANSIC(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		default:
+			// \\, \', or anything else: the escaped character itself.
+			b.WriteByte(s[i+1])
+		}
+		i++
+	}
+	return b.String()
+}
+
+func (e *Environment) Set(k, v string) string {
+	old := e.m[k]
+	e.m[k] = v
+	return old
+}
+
+func (e *Environment) Get(k string) (string, bool) {
+	v, ok := e.m[k]
+	return v, ok
+}
+
+func (e *Environment) Remove(k string) string {
+	v := e.m[k]
+	delete(e.m, k)
+	return v
+}
+
+func (e *Environment) ToMap() map[string]string {
+	out := map[string]string{}
+	for k, v := range e.m {
+		out[k] = v
+	}
+	return out
+}
+
+func (e *Environment) Diff(other *Environment) *Environment {
+	out := New()
+	for k, v := range e.m {
+		if ov, ok := other.m[k]; !ok || ov != v {
+			out.m[k] = v
+		}
+	}
+	return out
+}
+
+func (e *Environment) Merge(other *Environment) *Environment {
+	out := New()
+	for k, v := range e.m {
+		out.m[k] = v
+	}
+	if other != nil {
+		for k, v := range other.m {
+			out.m[k] = v
+		}
+	}
+	return out
+}